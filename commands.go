@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// openFileAndConnect resolves file's launcher and workspace root, connects
+// and performs the initialize handshake, then opens file in the server and
+// waits (up to cli.InitTimeout) for its initial workspace load and first
+// diagnostics batch, so a query issued right after isn't served from a
+// stale index.
+func openFileAndConnect(cli *CLI, file string) (*jsonrpc2.Conn, lsp.DocumentURI, error) {
+	launcher, err := detectLauncher(cli.Lang, file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rootURI, err := resolveRootURI(file, launcher.RootMarker())
+	if err != nil {
+		return nil, "", err
+	}
+
+	conn, lspConn, err := connectAndInitialize(cli, launcher, rootURI)
+	if err != nil {
+		return nil, "", err
+	}
+
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, "", err
+	}
+	uri := lsp.DocumentURI("file://" + absFile)
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	initCtx, cancel := context.WithTimeout(context.Background(), cli.InitTimeout)
+	defer cancel()
+
+	lspConn.WatchDiagnostics(uri)
+
+	log.Printf("Waiting for %s workspace load (up to %s)...", launcher.ID(), cli.InitTimeout)
+	if err := lspConn.WaitForWorkspaceReady(initCtx); err != nil {
+		log.Printf("Gave up waiting for %s workspace load: %s", launcher.ID(), err)
+	}
+
+	if err := didOpenFile(conn, uri, launcher.LanguageID(filepath.Ext(file)), string(text)); err != nil {
+		return nil, "", err
+	}
+
+	if err := lspConn.WaitForDiagnostics(initCtx); err != nil {
+		log.Printf("Gave up waiting for first diagnostics batch: %s", err)
+	}
+
+	return conn, uri, nil
+}
+
+// runSymbols implements `drillsp symbols <file>`: print the file's
+// top-level function names.
+func runSymbols(cli *CLI, file string) {
+	conn, uri, err := openFileAndConnect(cli, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req := lsp.DocumentSymbolParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+	}
+
+	log.Printf("Fetching document symbols for %s ...", uri)
+	var symbols []lsp.SymbolInformation
+	if err := conn.Call(context.Background(), "textDocument/documentSymbol", req, &symbols); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Fetched")
+
+	var names []string
+	for _, symbol := range symbols {
+		if symbol.Kind == lsp.SKFunction {
+			names = append(names, symbol.Name)
+		}
+	}
+
+	printOutput(cli.Format, names, func() {
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	})
+}
+
+// documentSymbol mirrors the hierarchical lsp.DocumentSymbol shape, which
+// go-lsp doesn't define since it predates LSP 3.10.
+type documentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           lsp.SymbolKind   `json:"kind"`
+	Range          lsp.Range        `json:"range"`
+	SelectionRange lsp.Range        `json:"selectionRange"`
+	Children       []documentSymbol `json:"children,omitempty"`
+}
+
+// runOutline implements `drillsp outline <file>`: print the full
+// textDocument/documentSymbol tree, hierarchical or flat depending on what
+// the server returns.
+func runOutline(cli *CLI, file string) {
+	conn, uri, err := openFileAndConnect(cli, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req := lsp.DocumentSymbolParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+	}
+
+	log.Printf("Fetching document symbol outline for %s ...", uri)
+	var raw json.RawMessage
+	if err := conn.Call(context.Background(), "textDocument/documentSymbol", req, &raw); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Fetched")
+
+	hierarchical, flat, err := decodeDocumentSymbolResponse(raw)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if hierarchical != nil {
+		printOutput(cli.Format, hierarchical, func() {
+			printOutlineTree(hierarchical, 0)
+		})
+		return
+	}
+
+	printOutput(cli.Format, flat, func() {
+		for _, symbol := range flat {
+			fmt.Printf("%s (%s)\n", symbol.Name, symbolKindName(symbol.Kind))
+		}
+	})
+}
+
+// decodeDocumentSymbolResponse decodes a textDocument/documentSymbol
+// response, whose array elements are shaped either as SymbolInformation
+// (flat, identified by a "location" field) or DocumentSymbol (hierarchical,
+// identified by a "range" field) depending on what the server negotiated.
+// For an empty (or null) response both return slices are nil; otherwise
+// exactly one of them is non-nil.
+func decodeDocumentSymbolResponse(raw json.RawMessage) ([]documentSymbol, []lsp.SymbolInformation, error) {
+	var probe []json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, nil, err
+	}
+	if len(probe) == 0 {
+		return nil, nil, nil
+	}
+
+	var shape struct {
+		Location json.RawMessage `json:"location"`
+	}
+	if err := json.Unmarshal(probe[0], &shape); err != nil {
+		return nil, nil, err
+	}
+
+	if shape.Location != nil {
+		var flat []lsp.SymbolInformation
+		if err := json.Unmarshal(raw, &flat); err != nil {
+			return nil, nil, err
+		}
+		return nil, flat, nil
+	}
+
+	var hierarchical []documentSymbol
+	if err := json.Unmarshal(raw, &hierarchical); err != nil {
+		return nil, nil, err
+	}
+	return hierarchical, nil, nil
+}
+
+// printOutlineTree prints a DocumentSymbol tree as indented text, one
+// symbol per line.
+func printOutlineTree(symbols []documentSymbol, depth int) {
+	for _, s := range symbols {
+		fmt.Printf("%s%s (%s)\n", strings.Repeat("  ", depth), s.Name, symbolKindName(s.Kind))
+		printOutlineTree(s.Children, depth+1)
+	}
+}
+
+// runSearch implements `drillsp search <query>`: run a workspace/symbol
+// query across the whole workspace rather than a single file. There's no
+// file to auto-detect the language from, so -lang is required.
+func runSearch(cli *CLI, query string) {
+	launcher, err := detectLauncher(cli.Lang, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rootURI := lsp.DocumentURI("file://" + cwd)
+
+	conn, lspConn, err := connectAndInitialize(cli, launcher, rootURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	initCtx, cancel := context.WithTimeout(context.Background(), cli.InitTimeout)
+	defer cancel()
+
+	log.Printf("Waiting for %s workspace load (up to %s)...", launcher.ID(), cli.InitTimeout)
+	if err := lspConn.WaitForWorkspaceReady(initCtx); err != nil {
+		log.Printf("Gave up waiting for %s workspace load: %s", launcher.ID(), err)
+	}
+
+	req := lsp.WorkspaceSymbolParams{Query: query}
+
+	log.Printf("Searching workspace symbols for %q ...", query)
+	var symbols []lsp.SymbolInformation
+	if err := conn.Call(context.Background(), "workspace/symbol", req, &symbols); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Fetched")
+
+	printOutput(cli.Format, symbols, func() {
+		for _, symbol := range symbols {
+			fmt.Printf("%s\t%s\t%s\n", symbol.Name, symbolKindName(symbol.Kind), symbol.Location.URI)
+		}
+	})
+}
+
+// printOutput prints v as indented JSON when format is "json", otherwise
+// runs printText to produce the plain-text rendering.
+func printOutput(format string, v any, printText func()) {
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printText()
+}
+
+// symbolKindNames maps the original (LSP 3.0) SymbolKind values to their
+// spec names; go-lsp predates the later additions (Object, Event, ...), so
+// anything outside this range falls back to its raw numeric value.
+var symbolKindNames = map[lsp.SymbolKind]string{
+	lsp.SKFile:        "File",
+	lsp.SKModule:      "Module",
+	lsp.SKNamespace:   "Namespace",
+	lsp.SKPackage:     "Package",
+	lsp.SKClass:       "Class",
+	lsp.SKMethod:      "Method",
+	lsp.SKProperty:    "Property",
+	lsp.SKField:       "Field",
+	lsp.SKConstructor: "Constructor",
+	lsp.SKEnum:        "Enum",
+	lsp.SKInterface:   "Interface",
+	lsp.SKFunction:    "Function",
+	lsp.SKVariable:    "Variable",
+	lsp.SKConstant:    "Constant",
+	lsp.SKString:      "String",
+	lsp.SKNumber:      "Number",
+	lsp.SKBoolean:     "Boolean",
+	lsp.SKArray:       "Array",
+}
+
+func symbolKindName(k lsp.SymbolKind) string {
+	if name, ok := symbolKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
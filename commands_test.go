@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeDocumentSymbolResponse(t *testing.T) {
+	tests := []struct {
+		name             string
+		raw              string
+		wantHierarchical int
+		wantFlat         int
+		wantErr          bool
+	}{
+		{
+			name:             "empty array",
+			raw:              `[]`,
+			wantHierarchical: 0,
+			wantFlat:         0,
+		},
+		{
+			name: "hierarchical DocumentSymbol",
+			raw: `[{
+				"name": "Foo",
+				"kind": 12,
+				"range": {"start": {"line": 0, "character": 0}, "end": {"line": 1, "character": 0}},
+				"selectionRange": {"start": {"line": 0, "character": 5}, "end": {"line": 0, "character": 8}},
+				"children": [{
+					"name": "bar",
+					"kind": 6,
+					"range": {"start": {"line": 1, "character": 1}, "end": {"line": 1, "character": 10}},
+					"selectionRange": {"start": {"line": 1, "character": 1}, "end": {"line": 1, "character": 4}}
+				}]
+			}]`,
+			wantHierarchical: 1,
+		},
+		{
+			name: "flat SymbolInformation",
+			raw: `[{
+				"name": "Foo",
+				"kind": 12,
+				"location": {
+					"uri": "file:///a.go",
+					"range": {"start": {"line": 0, "character": 0}, "end": {"line": 1, "character": 0}}
+				}
+			}]`,
+			wantFlat: 1,
+		},
+		{
+			name:    "not an array",
+			raw:     `{"name": "Foo"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hierarchical, flat, err := decodeDocumentSymbolResponse([]byte(tt.raw))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeDocumentSymbolResponse() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeDocumentSymbolResponse() error = %v", err)
+			}
+			if len(hierarchical) != tt.wantHierarchical {
+				t.Errorf("len(hierarchical) = %d, want %d", len(hierarchical), tt.wantHierarchical)
+			}
+			if len(flat) != tt.wantFlat {
+				t.Errorf("len(flat) = %d, want %d", len(flat), tt.wantFlat)
+			}
+			if tt.wantHierarchical > 0 && flat != nil {
+				t.Errorf("flat = %v, want nil when hierarchical is returned", flat)
+			}
+			if tt.wantFlat > 0 && hierarchical != nil {
+				t.Errorf("hierarchical = %v, want nil when flat is returned", hierarchical)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// drillspConfigFile holds per-section gopls settings (e.g. "gopls",
+// "build.buildFlags") that drillsp serves back when gopls asks for them via
+// workspace/configuration. Absent sections fall back to gopls' own default.
+const drillspConfigFile = ".drillsp.json"
+
+// loadDrillspConfig reads drillspConfigFile from the working directory, if
+// present, returning an empty config when it doesn't exist or fails to
+// parse.
+func loadDrillspConfig() map[string]any {
+	data, err := os.ReadFile(drillspConfigFile)
+	if err != nil {
+		return map[string]any{}
+	}
+
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Printf("Error parsing %s: %s", drillspConfigFile, err)
+		return map[string]any{}
+	}
+
+	return config
+}
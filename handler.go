@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// initWorkspaceProgressTitles are the $/progress begin.title values gopls
+// uses while it loads a workspace on a cold start. drillsp waits for a
+// matching "end" before trusting textDocument/documentSymbol results.
+var initWorkspaceProgressTitles = map[string]bool{
+	"Setting up workspace": true,
+	"Loading packages":     true,
+}
+
+// initProgressSettleDelay is how long WaitForWorkspaceReady waits after its
+// tracked init-progress sessions all end before declaring the workspace
+// ready. gopls sometimes reports its load as more than one session back to
+// back (e.g. "Setting up workspace" then "Loading packages"), and without a
+// settle window we'd race past the gap between them.
+const initProgressSettleDelay = 250 * time.Millisecond
+
+// LSPConnection implements jsonrpc2.Handler for requests and notifications
+// the language server sends back to drillsp (window/showMessage,
+// workspace/configuration, and so on). It also tracks $/progress and
+// textDocument/publishDiagnostics so a caller can wait for gopls to finish
+// its initial workspace load before issuing queries.
+type LSPConnection struct {
+	mu             sync.Mutex
+	initPending    map[any]struct{}
+	workspaceReady chan struct{}
+
+	diagURI      lsp.DocumentURI
+	diagReceived chan struct{}
+}
+
+// NewLSPConnection returns an LSPConnection ready to be handed to
+// jsonrpc2.NewConn. workspaceReady starts closed: until a tracked $/progress
+// "begin" says otherwise, there's nothing to wait on (e.g. a warm
+// -remote=auto daemon that reports no init progress on reconnect).
+//
+// This makes WaitForWorkspaceReady best-effort rather than a guarantee: it
+// only blocks if the server's first tracked "begin" arrives within
+// initProgressSettleDelay of the wait starting, so a begin that's merely
+// slow to arrive (cold start, server busy) can race past the gate before
+// indexing even starts. WatchDiagnostics/WaitForDiagnostics is the backstop
+// for that gap.
+func NewLSPConnection() *LSPConnection {
+	c := &LSPConnection{
+		initPending:    map[any]struct{}{},
+		workspaceReady: make(chan struct{}),
+	}
+	close(c.workspaceReady)
+	return c
+}
+
+func (c *LSPConnection) Handle(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
+	switch request.Method {
+	case "window/showMessage":
+		c.handleShowMessage(request)
+	case "window/logMessage":
+		c.handleLogMessage(request)
+	case "window/showMessageRequest":
+		// We have no UI to present actions through, so always decline.
+		c.handleShowMessage(request)
+		c.reply(ctx, conn, request, nil)
+	case "window/workDoneProgress/create":
+		c.reply(ctx, conn, request, nil)
+	case "$/progress":
+		c.handleProgress(request)
+	case "client/registerCapability", "client/unregisterCapability":
+		c.reply(ctx, conn, request, nil)
+	case "textDocument/publishDiagnostics":
+		c.handlePublishDiagnostics(request)
+	case "workspace/configuration":
+		c.reply(ctx, conn, request, c.handleWorkspaceConfiguration(request))
+	case "workspace/applyEdit":
+		log.Printf("Ignoring workspace/applyEdit, edit mode is not enabled")
+		c.reply(ctx, conn, request, applyWorkspaceEditResponse{Applied: false})
+	default:
+		c.handleUnknown(ctx, conn, request)
+	}
+}
+
+// reply sends result for requests and does nothing for notifications, since
+// jsonrpc2 notifications carry no ID and must not be replied to.
+func (c *LSPConnection) reply(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request, result any) {
+	if request.Notif {
+		return
+	}
+	if err := conn.Reply(ctx, request.ID, result); err != nil {
+		log.Printf("Error replying to %s: %s", request.Method, err)
+	}
+}
+
+func (c *LSPConnection) handleUnknown(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
+	log.Printf("Unhandled server request %s", request.Method)
+	if request.Notif {
+		return
+	}
+	err := &jsonrpc2.Error{
+		Code:    jsonrpc2.CodeMethodNotFound,
+		Message: fmt.Sprintf("drillsp: method not found: %s", request.Method),
+	}
+	if err := conn.ReplyWithError(ctx, request.ID, err); err != nil {
+		log.Printf("Error replying to %s: %s", request.Method, err)
+	}
+}
+
+func (c *LSPConnection) handleShowMessage(request *jsonrpc2.Request) {
+	var params lsp.ShowMessageParams
+	if err := json.Unmarshal(*request.Params, &params); err != nil {
+		log.Printf("Error parsing %s: %s", request.Method, err)
+		return
+	}
+
+	log.Printf("Server %s %s: %s", request.Method, messageTypePrefix(params.Type), params.Message)
+}
+
+func (c *LSPConnection) handleLogMessage(request *jsonrpc2.Request) {
+	var params logMessageParams
+	if err := json.Unmarshal(*request.Params, &params); err != nil {
+		log.Printf("Error parsing %s: %s", request.Method, err)
+		return
+	}
+
+	log.Printf("Server %s %s: %s", request.Method, messageTypePrefix(params.Type), params.Message)
+}
+
+type logMessageParams struct {
+	Type    lsp.MessageType `json:"type"`
+	Message string          `json:"message"`
+}
+
+func messageTypePrefix(t lsp.MessageType) string {
+	switch t {
+	case lsp.MTError:
+		return "Error"
+	case lsp.MTWarning:
+		return "Warning"
+	case lsp.Info:
+		return "Info"
+	case lsp.Log:
+		return "Log"
+	default:
+		return "Unknown"
+	}
+}
+
+// progressParams mirrors the $/progress notification payload. value is left
+// as a raw message since its shape depends on kind (begin/report/end).
+type progressParams struct {
+	Token any             `json:"token"`
+	Value json.RawMessage `json:"value"`
+}
+
+type progressValue struct {
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+}
+
+func (c *LSPConnection) handleProgress(request *jsonrpc2.Request) {
+	var params progressParams
+	if err := json.Unmarshal(*request.Params, &params); err != nil {
+		log.Printf("Error parsing $/progress: %s", err)
+		return
+	}
+
+	var value progressValue
+	if err := json.Unmarshal(params.Value, &value); err != nil {
+		log.Printf("Error parsing $/progress value: %s", err)
+		return
+	}
+
+	c.trackInitProgress(params.Token, value)
+
+	log.Printf("Progress %v %s %q", params.Token, value.Kind, value.Title)
+}
+
+// trackInitProgress records the lifetime of $/progress sessions whose
+// begin.title is in initWorkspaceProgressTitles, closing workspaceReady
+// once none remain outstanding.
+func (c *LSPConnection) trackInitProgress(token any, value progressValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch value.Kind {
+	case "begin":
+		if !initWorkspaceProgressTitles[value.Title] {
+			return
+		}
+		if len(c.initPending) == 0 {
+			c.workspaceReady = make(chan struct{})
+		}
+		c.initPending[token] = struct{}{}
+	case "end":
+		if _, ok := c.initPending[token]; !ok {
+			return
+		}
+		delete(c.initPending, token)
+		if len(c.initPending) == 0 {
+			close(c.workspaceReady)
+		}
+	}
+}
+
+// WaitForWorkspaceReady blocks until every $/progress session whose
+// begin.title matched initWorkspaceProgressTitles has reported its "end",
+// or ctx is done (typically via -init-timeout). It returns nil as soon as
+// gopls has been quiet on that front for initProgressSettleDelay, so a
+// multi-phase load doesn't race past us between sessions.
+func (c *LSPConnection) WaitForWorkspaceReady(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		ready := c.workspaceReady
+		c.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		select {
+		case <-time.After(initProgressSettleDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		c.mu.Lock()
+		settled := len(c.initPending) == 0
+		c.mu.Unlock()
+		if settled {
+			return nil
+		}
+	}
+}
+
+// publishDiagnosticsParams mirrors textDocument/publishDiagnostics' payload;
+// only URI is needed to satisfy WaitForDiagnostics.
+type publishDiagnosticsParams struct {
+	URI lsp.DocumentURI `json:"uri"`
+}
+
+// WatchDiagnostics arms WaitForDiagnostics to unblock on the first
+// textDocument/publishDiagnostics gopls sends for uri. It's a secondary,
+// best-effort readiness signal alongside WaitForWorkspaceReady.
+func (c *LSPConnection) WatchDiagnostics(uri lsp.DocumentURI) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.diagURI = uri
+	c.diagReceived = make(chan struct{})
+}
+
+// WaitForDiagnostics blocks until the URI passed to WatchDiagnostics
+// receives its first diagnostics batch, or ctx is done. It's a no-op if
+// WatchDiagnostics was never called.
+func (c *LSPConnection) WaitForDiagnostics(ctx context.Context) error {
+	c.mu.Lock()
+	received := c.diagReceived
+	c.mu.Unlock()
+	if received == nil {
+		return nil
+	}
+
+	select {
+	case <-received:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *LSPConnection) handlePublishDiagnostics(request *jsonrpc2.Request) {
+	var params publishDiagnosticsParams
+	if err := json.Unmarshal(*request.Params, &params); err != nil {
+		log.Printf("Error parsing textDocument/publishDiagnostics: %s", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.diagReceived == nil || params.URI != c.diagURI {
+		return
+	}
+	select {
+	case <-c.diagReceived:
+	default:
+		close(c.diagReceived)
+	}
+}
+
+// configurationParams mirrors workspace/configuration's request payload.
+type configurationParams struct {
+	Items []struct {
+		ScopeURI string `json:"scopeUri"`
+		Section  string `json:"section"`
+	} `json:"items"`
+}
+
+// applyWorkspaceEditResponse mirrors workspace/applyEdit's response payload.
+type applyWorkspaceEditResponse struct {
+	Applied bool `json:"applied"`
+}
+
+// handleWorkspaceConfiguration answers gopls' workspace/configuration
+// request with one entry per requested item, preferring a value from the
+// drillsp config file and falling back to nil (the LSP "no opinion,
+// use your default" value) otherwise.
+func (c *LSPConnection) handleWorkspaceConfiguration(request *jsonrpc2.Request) []any {
+	var params configurationParams
+	if err := json.Unmarshal(*request.Params, &params); err != nil {
+		log.Printf("Error parsing workspace/configuration: %s", err)
+		return nil
+	}
+
+	config := loadDrillspConfig()
+
+	result := make([]any, len(params.Items))
+	for i, item := range params.Items {
+		if v, ok := config[item.Section]; ok {
+			result[i] = v
+		}
+	}
+	return result
+}
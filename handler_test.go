@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTrackInitProgressReadyWithNoBegin(t *testing.T) {
+	c := NewLSPConnection()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.WaitForWorkspaceReady(ctx); err != nil {
+		t.Fatalf("WaitForWorkspaceReady() = %v, want nil when no init progress was ever tracked", err)
+	}
+}
+
+func TestTrackInitProgressBlocksUntilEnd(t *testing.T) {
+	c := NewLSPConnection()
+	c.trackInitProgress("tok", progressValue{Kind: "begin", Title: "Loading packages"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForWorkspaceReady(ctx); err == nil {
+		t.Fatalf("WaitForWorkspaceReady() = nil, want timeout while a tracked session is still pending")
+	}
+
+	c.trackInitProgress("tok", progressValue{Kind: "end"})
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if err := c.WaitForWorkspaceReady(ctx2); err != nil {
+		t.Fatalf("WaitForWorkspaceReady() = %v, want nil once the tracked session has ended", err)
+	}
+}
+
+func TestTrackInitProgressIgnoresUntrackedTitles(t *testing.T) {
+	c := NewLSPConnection()
+	c.trackInitProgress("tok", progressValue{Kind: "begin", Title: "Some unrelated task"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.WaitForWorkspaceReady(ctx); err != nil {
+		t.Fatalf("WaitForWorkspaceReady() = %v, want nil for a begin.title outside initWorkspaceProgressTitles", err)
+	}
+}
+
+func TestTrackInitProgressSequentialSessions(t *testing.T) {
+	c := NewLSPConnection()
+
+	c.trackInitProgress("a", progressValue{Kind: "begin", Title: "Setting up workspace"})
+	c.trackInitProgress("a", progressValue{Kind: "end"})
+	c.trackInitProgress("b", progressValue{Kind: "begin", Title: "Loading packages"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForWorkspaceReady(ctx); err == nil {
+		t.Fatalf("WaitForWorkspaceReady() = nil, want timeout while the second session is still pending")
+	}
+
+	c.trackInitProgress("b", progressValue{Kind: "end"})
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if err := c.WaitForWorkspaceReady(ctx2); err != nil {
+		t.Fatalf("WaitForWorkspaceReady() = %v, want nil once both sessions have ended", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Package lsprpc provides transport-agnostic helpers for wiring up a
+// jsonrpc2 connection to a language server, whether that's a freshly
+// spawned stdio child process or an already-running TCP/Unix daemon.
+package lsprpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// StreamServer connects a transport to a jsonrpc2.Handler and returns the
+// resulting connection. Implementations must fully construct handler before
+// handing it to jsonrpc2.NewConn: NewConn starts reading immediately, so a
+// handler that's still being wired up after that call can race with
+// server->client requests (window/showMessage, workspace/configuration, ...)
+// arriving on the wire.
+type StreamServer interface {
+	ServeStream(ctx context.Context, handler jsonrpc2.Handler) (*jsonrpc2.Conn, error)
+}
+
+// StdioServer spawns Cmd with Args and speaks jsonrpc2 over its stdin and
+// stdout, logging stderr through to this process's stderr.
+type StdioServer struct {
+	Cmd  string
+	Args []string
+}
+
+func (s StdioServer) ServeStream(ctx context.Context, handler jsonrpc2.Handler) (*jsonrpc2.Conn, error) {
+	cmd := exec.Command(s.Cmd, s.Args...)
+	cmd.Env = os.Environ()
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	go io.Copy(os.Stderr, stderr)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	conn := newConn(pipeConn{in, out}, handler)
+
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	return conn, nil
+}
+
+// DialServer connects to an already-running language server daemon over the
+// given network ("tcp" or "unix"), avoiding a fresh process startup/index
+// for every invocation.
+type DialServer struct {
+	Network string
+	Address string
+}
+
+func (s DialServer) ServeStream(ctx context.Context, handler jsonrpc2.Handler) (*jsonrpc2.Conn, error) {
+	netConn, err := net.Dial(s.Network, s.Address)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s %s: %w", s.Network, s.Address, err)
+	}
+	return newConn(netConn, handler), nil
+}
+
+// newConn builds the jsonrpc2.Conn as the very last step, after handler has
+// been fully constructed, so there is no window in which a request can
+// arrive for a not-yet-wired handler.
+func newConn(netConn net.Conn, handler jsonrpc2.Handler) *jsonrpc2.Conn {
+	stream := jsonrpc2.NewBufferedStream(netConn, jsonrpc2.VSCodeObjectCodec{})
+	return jsonrpc2.NewConn(context.Background(), stream, handler)
+}
+
+// pipeConn adapts a child process's stdin/stdout pipes to the net.Conn
+// interface expected by jsonrpc2 streams. Addressing and deadlines aren't
+// meaningful for a pipe pair.
+type pipeConn struct {
+	io.WriteCloser
+	io.ReadCloser
+}
+
+func (c pipeConn) Close() error {
+	werr := c.WriteCloser.Close()
+	rerr := c.ReadCloser.Close()
+	if werr != nil {
+		return werr
+	}
+	return rerr
+}
+
+func (pipeConn) LocalAddr() net.Addr                { return nil }
+func (pipeConn) RemoteAddr() net.Addr               { return nil }
+func (pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (pipeConn) SetWriteDeadline(t time.Time) error { return nil }
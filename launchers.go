@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// ServerLauncher knows how to drive one language server: the command used
+// to spawn or dial it, and the handshake details (languageId, workspace
+// root marker, initializationOptions) that differ per server. Selected via
+// -lang (or auto-detected from the target file's extension).
+type ServerLauncher interface {
+	// ID is this launcher's language id, used for -lang.
+	ID() string
+	// Extensions are the file extensions (with leading dot) auto-detection
+	// maps to this launcher.
+	Extensions() []string
+	// LanguageID is the textDocument/didOpen languageId to use for a file
+	// with the given extension. Usually just ID(), but launchers that cover
+	// more than one LSP languageId (e.g. typescript-language-server serving
+	// both "typescript" and "javascript") resolve per extension instead.
+	LanguageID(ext string) string
+	// Command is the default stdio command and args used to spawn the
+	// server; -server overrides it.
+	Command() (string, []string)
+	// ListenArgs returns the args used in place of Command()'s default args
+	// to make the server listen on a unix socket at sockPath, for
+	// -remote=auto's shared-daemon mode, and whether this launcher supports
+	// that mode at all.
+	ListenArgs(sockPath string) ([]string, bool)
+	// RootMarker is the filename resolveRootURI looks for while walking up
+	// from the target file to find the workspace root (e.g. "go.mod").
+	RootMarker() string
+	// InitializationOptions builds this server's
+	// InitializeParams.InitializationOptions payload.
+	InitializationOptions(cli *CLI) any
+}
+
+// goLauncher drives gopls.
+type goLauncher struct{}
+
+func (goLauncher) ID() string               { return "go" }
+func (goLauncher) Extensions() []string     { return []string{".go"} }
+func (goLauncher) LanguageID(string) string { return "go" }
+func (goLauncher) RootMarker() string       { return "go.mod" }
+
+func (goLauncher) InitializationOptions(cli *CLI) any {
+	return buildInitializationOptions(cli)
+}
+
+func (goLauncher) Command() (string, []string) {
+	return "gopls", []string{"-logfile=./gopls.log", "-rpc.trace", "-vv", "-mode=stdio"}
+}
+
+func (goLauncher) ListenArgs(sockPath string) ([]string, bool) {
+	return []string{"-logfile=./gopls.log", fmt.Sprintf("-listen=unix;%s", sockPath)}, true
+}
+
+// genericLauncher drives a language server that needs nothing beyond a
+// stdio command and a workspace root marker: no shared-daemon mode, and no
+// server-specific initializationOptions.
+type genericLauncher struct {
+	id       string
+	exts     []string
+	cmd      string
+	args     []string
+	rootMark string
+	// langByExt overrides LanguageID per extension for a launcher whose
+	// server covers more than one LSP languageId (e.g. .js vs .ts under
+	// typescript-language-server). Extensions absent from this map fall
+	// back to id.
+	langByExt map[string]string
+}
+
+func (l genericLauncher) ID() string           { return l.id }
+func (l genericLauncher) Extensions() []string { return l.exts }
+func (l genericLauncher) LanguageID(ext string) string {
+	if id, ok := l.langByExt[ext]; ok {
+		return id
+	}
+	return l.id
+}
+func (l genericLauncher) Command() (string, []string) {
+	return l.cmd, l.args
+}
+func (l genericLauncher) RootMarker() string { return l.rootMark }
+func (genericLauncher) InitializationOptions(*CLI) any {
+	return nil
+}
+func (genericLauncher) ListenArgs(string) ([]string, bool) {
+	return nil, false
+}
+
+// launcherRegistry maps language ids to their launcher.
+var launcherRegistry = buildLauncherRegistry()
+
+// extensionRegistry maps a file extension to the launcher that handles it
+// by default, derived from launcherRegistry.
+var extensionRegistry = buildExtensionRegistry()
+
+func buildLauncherRegistry() map[string]ServerLauncher {
+	launchers := []ServerLauncher{
+		goLauncher{},
+		genericLauncher{
+			id:       "rust",
+			exts:     []string{".rs"},
+			cmd:      "rust-analyzer",
+			rootMark: "Cargo.toml",
+		},
+		genericLauncher{
+			id:       "python",
+			exts:     []string{".py"},
+			cmd:      "pyright-langserver",
+			args:     []string{"--stdio"},
+			rootMark: "pyproject.toml",
+		},
+		genericLauncher{
+			id:       "cpp",
+			exts:     []string{".c", ".cc", ".cpp", ".h", ".hpp"},
+			cmd:      "clangd",
+			rootMark: "compile_commands.json",
+		},
+		genericLauncher{
+			id:       "typescript",
+			exts:     []string{".ts", ".tsx", ".js", ".jsx"},
+			cmd:      "typescript-language-server",
+			args:     []string{"--stdio"},
+			rootMark: "package.json",
+			langByExt: map[string]string{
+				".ts":  "typescript",
+				".tsx": "typescriptreact",
+				".js":  "javascript",
+				".jsx": "javascriptreact",
+			},
+		},
+	}
+
+	registry := make(map[string]ServerLauncher, len(launchers))
+	for _, l := range launchers {
+		registry[l.ID()] = l
+	}
+	return registry
+}
+
+func buildExtensionRegistry() map[string]ServerLauncher {
+	registry := map[string]ServerLauncher{}
+	for _, l := range launcherRegistry {
+		for _, ext := range l.Extensions() {
+			registry[ext] = l
+		}
+	}
+	return registry
+}
+
+// detectLauncher resolves the launcher to drive: an explicit -lang id
+// takes priority, otherwise it's auto-detected from file's extension.
+// file may be empty when lang is given explicitly (e.g. for search).
+func detectLauncher(lang, file string) (ServerLauncher, error) {
+	if lang != "" {
+		l, ok := launcherRegistry[lang]
+		if !ok {
+			return nil, fmt.Errorf("unknown -lang %q", lang)
+		}
+		return l, nil
+	}
+
+	if file == "" {
+		return nil, fmt.Errorf("-lang is required when no file is given")
+	}
+
+	ext := filepath.Ext(file)
+	l, ok := extensionRegistry[ext]
+	if !ok {
+		return nil, fmt.Errorf("no language server registered for extension %q; pass -lang explicitly", ext)
+	}
+	return l, nil
+}
+
+// resolveRootURI walks up from the directory containing file looking for
+// marker (e.g. "go.mod"), and returns the first directory that contains it
+// as the workspace root. If marker is empty or never found, file's own
+// directory is used as a fallback root.
+func resolveRootURI(file, marker string) (lsp.DocumentURI, error) {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return "", err
+	}
+
+	root := dir
+	if marker != "" {
+		for d := dir; ; {
+			if _, err := os.Stat(filepath.Join(d, marker)); err == nil {
+				root = d
+				break
+			}
+			parent := filepath.Dir(d)
+			if parent == d {
+				break
+			}
+			d = parent
+		}
+	}
+
+	return lsp.DocumentURI("file://" + root), nil
+}
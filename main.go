@@ -2,79 +2,141 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/bakks/drillsp/internal/lsprpc"
 	"github.com/sourcegraph/go-lsp"
 	"github.com/sourcegraph/jsonrpc2"
 )
 
 type CLI struct {
+	Remote      string        `help:"Connect to the language server instead of spawning a private stdio subprocess. Accepts host:port, unix:/path/to/sock, or auto to discover/start a shared per-user daemon."`
+	Lang        string        `name:"lang" help:"Language id to drive (go, rust, python, cpp, typescript). Auto-detected from the target file's extension when omitted; required for search."`
+	Server      string        `name:"server" help:"Override the selected launcher's stdio command, e.g. -server=\"rust-analyzer --log-file=/tmp/ra.log\"."`
+	Tags        string        `name:"tags" help:"Comma-separated build tags, forwarded to gopls via InitializationOptions.buildFlags."`
+	BuildFlags  string        `name:"buildflags" help:"Comma-separated extra go build flags, forwarded to gopls via InitializationOptions.buildFlags."`
+	InitTimeout time.Duration `name:"init-timeout" default:"15s" help:"Max time to wait for the server's initial workspace load/indexing before issuing a query anyway."`
+	Format      string        `name:"format" enum:"text,json" default:"text" help:"Output format: text or json."`
+
+	Symbols SymbolsCmd `cmd:"" help:"Print top-level function names declared in a file."`
+	Outline OutlineCmd `cmd:"" help:"Print the hierarchical documentSymbol tree for a file."`
+	Search  SearchCmd  `cmd:"" help:"Run a workspace/symbol query across the workspace."`
+}
+
+// SymbolsCmd is `drillsp symbols <file>`: it prints the file's top-level
+// function names, drillsp's original behavior.
+type SymbolsCmd struct {
+	File string `arg:"" type:"path" help:"File to analyze."`
+}
+
+// OutlineCmd is `drillsp outline <file>`: it prints the full hierarchical
+// textDocument/documentSymbol tree for the file.
+type OutlineCmd struct {
 	File string `arg:"" type:"path" help:"File to analyze."`
 }
 
+// SearchCmd is `drillsp search <query>`: it runs a workspace/symbol query
+// across the whole workspace rather than a single file.
+type SearchCmd struct {
+	Query string `arg:"" help:"Workspace symbol query string."`
+}
+
 func main() {
 	var cli CLI
-	kong.Parse(&cli)
+	kctx := kong.Parse(&cli)
+
+	switch kctx.Command() {
+	case "symbols <file>":
+		runSymbols(&cli, cli.Symbols.File)
+	case "outline <file>":
+		runOutline(&cli, cli.Outline.File)
+	case "search <query>":
+		runSearch(&cli, cli.Search.Query)
+	default:
+		log.Fatalf("drillsp: unhandled command %q", kctx.Command())
+	}
+}
 
-	// Start the Go language server
-	conn, err := startGoLanguageServer()
+// connectAndInitialize starts (or dials) launcher's language server and
+// performs the initialize/initialized handshake against rootURI. It
+// returns the resulting connection and the handler tracking its reverse
+// requests.
+func connectAndInitialize(cli *CLI, launcher ServerLauncher, rootURI lsp.DocumentURI) (*jsonrpc2.Conn, *LSPConnection, error) {
+	lspConn := NewLSPConnection()
+
+	conn, err := startLanguageServer(cli.Remote, cli.Server, launcher, lspConn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	path := "/Users/bakks/drillsp"
-	// Initialize the language server
-	if err := initializeLanguageServer(path, conn); err != nil {
-		log.Fatal(err)
+	if err := initializeLanguageServer(rootURI, conn, cli, launcher); err != nil {
+		return nil, nil, err
 	}
 	if err := initializedLanguageServer(conn); err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	uri := lsp.DocumentURI("file://" + cli.File)
-	text, err := ioutil.ReadFile(cli.File)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return conn, lspConn, nil
+}
 
-	didOpenFile(conn, uri, string(text))
+// initializeParams extends lsp.InitializeParams with the window and
+// textDocument.documentSymbol capabilities drillsp needs, which this fork of
+// go-lsp predates. Capabilities here shadows the
+// embedded field of the same JSON name, so it's sent in place of the
+// (always-empty) one lsp.InitializeParams would otherwise marshal.
+type initializeParams struct {
+	lsp.InitializeParams
+	Capabilities clientCapabilities `json:"capabilities"`
+}
 
-	// Send a textDocument/documentSymbol request
-	req := lsp.DocumentSymbolParams{
-		TextDocument: lsp.TextDocumentIdentifier{
-			URI: uri,
-		},
-	}
+type clientCapabilities struct {
+	Window       windowClientCapabilities       `json:"window"`
+	TextDocument textDocumentClientCapabilities `json:"textDocument"`
+}
 
-	log.Printf("Fetching document symbols for %s ...", uri)
-	var symbols []lsp.SymbolInformation
-	if err := conn.Call(context.Background(), "textDocument/documentSymbol", req, &symbols); err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("Fetched")
+// windowClientCapabilities advertises support for window/workDoneProgress,
+// which is what makes a server report $/progress for its initial workspace
+// load instead of staying silent about it.
+type windowClientCapabilities struct {
+	WorkDoneProgress bool `json:"workDoneProgress"`
+}
 
-	// Print the function names
-	for _, symbol := range symbols {
-		if symbol.Kind == lsp.SKFunction {
-			fmt.Println(symbol.Name)
-		}
-	}
+type textDocumentClientCapabilities struct {
+	DocumentSymbol documentSymbolClientCapabilities `json:"documentSymbol"`
+}
+
+// documentSymbolClientCapabilities advertises support for the hierarchical
+// DocumentSymbol shape, which is what makes a server return a tree (with
+// children) from textDocument/documentSymbol instead of a flat
+// []SymbolInformation list.
+type documentSymbolClientCapabilities struct {
+	HierarchicalDocumentSymbolSupport bool `json:"hierarchicalDocumentSymbolSupport"`
 }
 
 // func to initialize the LSP server over jsonrpc2
-func initializeLanguageServer(path string, conn *jsonrpc2.Conn) error {
+func initializeLanguageServer(rootURI lsp.DocumentURI, conn *jsonrpc2.Conn, cli *CLI, launcher ServerLauncher) error {
 	log.Printf("Initializing LSP server...")
-	req := lsp.InitializeParams{
-		RootURI: lsp.DocumentURI("file://" + path),
+	req := initializeParams{
+		InitializeParams: lsp.InitializeParams{
+			RootURI:               rootURI,
+			InitializationOptions: launcher.InitializationOptions(cli),
+		},
+		Capabilities: clientCapabilities{
+			Window: windowClientCapabilities{WorkDoneProgress: true},
+			TextDocument: textDocumentClientCapabilities{
+				DocumentSymbol: documentSymbolClientCapabilities{HierarchicalDocumentSymbolSupport: true},
+			},
+		},
 	}
 
 	var resp lsp.InitializeResult
@@ -87,6 +149,40 @@ func initializeLanguageServer(path string, conn *jsonrpc2.Conn) error {
 	return nil
 }
 
+// buildInitializationOptions captures the caller's Go environment so a
+// possibly-shared gopls daemon (see -remote) computes symbols under the same
+// build configuration as the invoking shell, rather than whatever
+// environment the daemon happened to be started with.
+func buildInitializationOptions(cli *CLI) map[string]any {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if key == "PATH" || strings.HasPrefix(key, "GO") || strings.HasPrefix(key, "CGO_") {
+			env[key] = val
+		}
+	}
+
+	opts := map[string]any{
+		"env": env,
+	}
+
+	var buildFlags []string
+	if cli.Tags != "" {
+		buildFlags = append(buildFlags, "-tags="+cli.Tags)
+	}
+	if cli.BuildFlags != "" {
+		buildFlags = append(buildFlags, strings.Split(cli.BuildFlags, ",")...)
+	}
+	if len(buildFlags) > 0 {
+		opts["buildFlags"] = buildFlags
+	}
+
+	return opts
+}
+
 // send initialized notification
 func initializedLanguageServer(conn *jsonrpc2.Conn) error {
 	log.Printf("Sending initialized notification...")
@@ -102,13 +198,13 @@ func initializedLanguageServer(conn *jsonrpc2.Conn) error {
 }
 
 // func to send a didopen on the target file
-func didOpenFile(conn *jsonrpc2.Conn, uri lsp.DocumentURI, text string) error {
+func didOpenFile(conn *jsonrpc2.Conn, uri lsp.DocumentURI, languageID, text string) error {
 	log.Printf("Sending didOpen for %s ...", uri)
 	// Send a textDocument/didOpen notification
 	notification := lsp.DidOpenTextDocumentParams{
 		TextDocument: lsp.TextDocumentItem{
 			URI:        uri,
-			LanguageID: "go",
+			LanguageID: languageID,
 			Version:    1,
 			Text:       text,
 		},
@@ -122,113 +218,125 @@ func didOpenFile(conn *jsonrpc2.Conn, uri lsp.DocumentURI, text string) error {
 	return nil
 }
 
-type LSPConnection struct {
-}
-
-func (this *LSPConnection) Handle(ctx context.Context, conn *jsonrpc2.Conn, request *jsonrpc2.Request) {
-	params := request.Params
-
-	// parse json as a lsp.ShowMessageParams message
-	var showMessageParams lsp.ShowMessageParams
-	if err := json.Unmarshal(*params, &showMessageParams); err != nil {
-		log.Printf("Error parsing message: %s", err)
-		return
-	}
-
-	var prefix string
-
-	switch showMessageParams.Type {
-	case lsp.MTError:
-		prefix = "Error"
-	case lsp.MTWarning:
-		prefix = "Warning"
-	case lsp.Info:
-		prefix = "Info"
-	case lsp.Log:
-		prefix = "Log"
-	default:
-		panic("unexpected message type")
-	}
-
-	log.Printf("Server notification %s %s %s: %s", request.Method, request.ID, prefix, showMessageParams.Message)
-}
-
-func startGoLanguageServer() (*jsonrpc2.Conn, error) {
-	log.Printf("Starting Golang LSP server...")
-	cmd := exec.Command("gopls", "-logfile=./gopls.log", "-rpc.trace", "-vv", "-mode=stdio")
-	cmd.Env = os.Environ()
-
-	in, err := cmd.StdinPipe()
+// startLanguageServer either spawns a private stdio child process for
+// launcher (remote == "") or connects to an already-running daemon. remote
+// may be "host:port", "unix:/path/to/sock", or "auto" to discover (and
+// start if the launcher supports it) a per-user daemon shared across
+// drillsp invocations. serverOverride, if set, replaces launcher's default
+// stdio command and args.
+func startLanguageServer(remote, serverOverride string, launcher ServerLauncher, handler *LSPConnection) (*jsonrpc2.Conn, error) {
+	server, err := languageStreamServer(remote, serverOverride, launcher)
 	if err != nil {
 		return nil, err
 	}
-	out, err := cmd.StdoutPipe()
+
+	log.Printf("Starting %s language server...", launcher.ID())
+	conn, err := server.ServeStream(context.Background(), handler)
 	if err != nil {
 		return nil, err
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+	log.Printf("Started")
+
+	return conn, nil
+}
+
+func languageStreamServer(remote, serverOverride string, launcher ServerLauncher) (lsprpc.StreamServer, error) {
+	cmd, args := launcher.Command()
+	if serverOverride != "" {
+		fields := strings.Fields(serverOverride)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("-server must not be blank")
+		}
+		cmd, args = fields[0], fields[1:]
 	}
-	go io.Copy(os.Stderr, stderr)
 
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	switch {
+	case remote == "":
+		return lsprpc.StdioServer{Cmd: cmd, Args: args}, nil
+	case remote == "auto":
+		sockPath, err := autoSocketPath(launcher.ID())
+		if err != nil {
+			return nil, err
+		}
+		if err := ensureAutoDaemon(sockPath, cmd, launcher); err != nil {
+			return nil, err
+		}
+		return lsprpc.DialServer{Network: "unix", Address: sockPath}, nil
+	case strings.HasPrefix(remote, "unix:"):
+		return lsprpc.DialServer{Network: "unix", Address: strings.TrimPrefix(remote, "unix:")}, nil
+	default:
+		return lsprpc.DialServer{Network: "tcp", Address: remote}, nil
 	}
+}
 
-	outReader := &readerLogger{out}
-	inWriter := &writerLogger{in}
-	netConn := readWriteNetConn{inWriter, outReader}
+// ensureAutoDaemon starts a daemon bound to sockPath, via cmd and
+// launcher's listen-mode args, if one isn't already accepting connections
+// there. cmd is whatever the caller resolved to run the server (the
+// launcher's default, or -server's override).
+func ensureAutoDaemon(sockPath, cmd string, launcher ServerLauncher) error {
+	if probeConn, err := net.Dial("unix", sockPath); err == nil {
+		probeConn.Close()
+		return nil
+	}
 
-	stream := jsonrpc2.NewBufferedStream(netConn, jsonrpc2.VSCodeObjectCodec{})
-	lspConn := &LSPConnection{}
-	conn := jsonrpc2.NewConn(context.Background(), stream, lspConn)
-	log.Printf("Started")
+	listenArgs, ok := launcher.ListenArgs(sockPath)
+	if !ok {
+		return fmt.Errorf("%s does not support -remote=auto (no shared-daemon listen mode); start one yourself and pass -remote=unix:/path or host:port", launcher.ID())
+	}
 
-	// goroutine to check if the command exits
+	log.Printf("No daemon found at %s, starting one...", sockPath)
+	c := exec.Command(cmd, listenArgs...)
+	c.Env = os.Environ()
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("start %s daemon: %w", launcher.ID(), err)
+	}
+	// Detach: we don't block on the daemon, it outlives this process; this
+	// goroutine only reaps it and logs its exit.
 	go func() {
-		if err := cmd.Wait(); err != nil {
-			log.Printf("Command exited with error: %s", err)
-		} else {
-			log.Printf("Command exited")
+		if err := c.Wait(); err != nil {
+			log.Printf("%s daemon exited with error: %s", launcher.ID(), err)
 		}
 	}()
 
-	return conn, nil
-}
-
-// implements net.Conn interface
-type readWriteNetConn struct {
-	io.Writer
-	io.Reader
-}
-
-func (readWriteNetConn) Close() error                       { panic("unimplemented") }
-func (readWriteNetConn) LocalAddr() net.Addr                { panic("unimplemented") }
-func (readWriteNetConn) RemoteAddr() net.Addr               { panic("unimplemented") }
-func (readWriteNetConn) SetDeadline(t time.Time) error      { panic("unimplemented") }
-func (readWriteNetConn) SetReadDeadline(t time.Time) error  { panic("unimplemented") }
-func (readWriteNetConn) SetWriteDeadline(t time.Time) error { panic("unimplemented") }
+	var lastErr error
+	for i := 0; i < 50; i++ {
+		probeConn, err := net.Dial("unix", sockPath)
+		if err == nil {
+			probeConn.Close()
+			return nil
+		}
+		lastErr = err
+		time.Sleep(100 * time.Millisecond)
+	}
 
-// this implements io.Reader and logs calls to Read before forwarding on the read
-type readerLogger struct {
-	io.Reader
+	return fmt.Errorf("gave up waiting for %s daemon at %s: %w", launcher.ID(), sockPath, lastErr)
 }
 
-func (this *readerLogger) Read(p []byte) (int, error) {
-	n, err := this.Reader.Read(p)
-	//log.Printf("Read %d bytes: %s", n, string(p))
-	return n, err
-}
+// autoSocketPath returns a deterministic per-user, per-toolchain socket
+// path so that multiple drillsp invocations against the same launcher and
+// Go toolchain, run by the same user, share the same warm daemon cache.
+// The uid is baked into the name because XDG_RUNTIME_DIR's os.TempDir()
+// fallback is world-shared, and without it two users would dial (or race
+// to bind) the same socket.
+func autoSocketPath(launcherID string) (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
 
-// this implements io.Writer and logs calls to Write before forwarding on the write
-type writerLogger struct {
-	io.Writer
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s@%s@uid%d", launcherID, toolchainVersion(), os.Getuid())))
+	name := fmt.Sprintf("drillsp-%s-%x.sock", launcherID, h[:4])
+	return filepath.Join(dir, name), nil
 }
 
-func (this *writerLogger) Write(p []byte) (int, error) {
-	//log.Printf("Write %d bytes: %s", len(p), string(p))
-	n, err := this.Writer.Write(p)
-
-	return n, err
+// toolchainVersion returns the active `go` toolchain's version (e.g.
+// "go1.22.3"), the one gopls will actually run under, as opposed to
+// runtime.Version() which is only the Go version drillsp itself was built
+// with. Falls back to runtime.Version() if `go` isn't on PATH.
+func toolchainVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return runtime.Version()
+	}
+	return strings.TrimSpace(string(out))
 }